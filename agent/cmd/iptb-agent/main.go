@@ -0,0 +1,44 @@
+// Command iptb-agent runs a single TestbedNode and exposes it over gRPC
+// so a RemoteNode on another host can drive it as if it were local.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/casebell/iptb/agent"
+	"github.com/casebell/iptb/agent/pb"
+	iptbutil "github.com/casebell/iptb/util"
+
+	"google.golang.org/grpc"
+)
+
+func main() {
+	listenAddr := flag.String("listen", ":41110", "address to listen for agent RPCs on")
+	dir := flag.String("dir", "", "repo path of the node this agent manages")
+	flag.Parse()
+
+	if *dir == "" {
+		fmt.Fprintln(os.Stderr, "iptb-agent: -dir is required")
+		os.Exit(1)
+	}
+
+	node := &iptbutil.LocalNode{Dir: *dir}
+
+	lis, err := net.Listen("tcp", *listenAddr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "iptb-agent: %s\n", err)
+		os.Exit(1)
+	}
+
+	srv := grpc.NewServer()
+	pb.RegisterAgentServer(srv, agent.New(node))
+
+	fmt.Printf("iptb-agent: serving %s on %s\n", *dir, *listenAddr)
+	if err := srv.Serve(lis); err != nil {
+		fmt.Fprintf(os.Stderr, "iptb-agent: %s\n", err)
+		os.Exit(1)
+	}
+}
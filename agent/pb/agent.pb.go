@@ -0,0 +1,388 @@
+// Package pb mirrors the service and message shapes declared in
+// agent.proto. It is hand-maintained rather than protoc-generated: this
+// tree has no protoc/protoc-gen-go-grpc available, so the message types
+// below are plain structs sent over the wire by the JSON codec
+// registered in codec.go (under the name grpc-go picks by default,
+// "proto") instead of real protobuf encoding. If protoc becomes
+// available, replace this file and codec.go with the generated
+// output and drop the custom codec.
+package pb
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+)
+
+type Output_Stream int32
+
+const (
+	Output_STDOUT Output_Stream = 0
+	Output_STDERR Output_Stream = 1
+)
+
+type Event_Kind int32
+
+const (
+	Event_STARTED   Event_Kind = 0
+	Event_DIED      Event_Kind = 1
+	Event_SAMPLE    Event_Kind = 2
+	Event_RESTARTED Event_Kind = 3
+	Event_OUTPUT    Event_Kind = 4
+)
+
+type InitRequest struct{}
+type InitReply struct{}
+
+type StartRequest struct {
+	Args []string
+}
+type StartReply struct {
+	PeerId string
+}
+
+type KillRequest struct{}
+type KillReply struct{}
+
+type RunCmdRequest struct {
+	Args []string
+}
+
+type RunCmdReply struct {
+	Output string
+}
+
+type Output struct {
+	Stream Output_Stream
+	Data   []byte
+}
+
+type StateRequest struct{}
+type StateReply struct {
+	Running bool
+}
+
+type EventsRequest struct{}
+
+type Event struct {
+	Kind     Event_Kind
+	UnixNano int64
+	Output   *Output
+	// Sample is only populated for Kind == Event_SAMPLE; it mirrors
+	// iptbutil.NodeSample.
+	Sample *Sample
+}
+
+type Sample struct {
+	BwIn          uint64
+	BwOut         uint64
+	CpuUsage      uint64
+	MemoryCurrent uint64
+	RepoSize      uint64
+	PeerCount     int64
+}
+
+type APIAddrRequest struct{}
+type APIAddrReply struct {
+	Addr string
+}
+
+type GetConfigRequest struct{}
+type GetConfigReply struct {
+	ConfigJson []byte
+}
+
+type WriteConfigRequest struct {
+	ConfigJson []byte
+}
+type WriteConfigReply struct{}
+
+// AgentClient is the client API for the Agent service.
+type AgentClient interface {
+	Init(ctx context.Context, in *InitRequest, opts ...grpc.CallOption) (*InitReply, error)
+	Start(ctx context.Context, in *StartRequest, opts ...grpc.CallOption) (*StartReply, error)
+	Kill(ctx context.Context, in *KillRequest, opts ...grpc.CallOption) (*KillReply, error)
+	RunCmd(ctx context.Context, in *RunCmdRequest, opts ...grpc.CallOption) (*RunCmdReply, error)
+	State(ctx context.Context, in *StateRequest, opts ...grpc.CallOption) (*StateReply, error)
+	Events(ctx context.Context, in *EventsRequest, opts ...grpc.CallOption) (Agent_EventsClient, error)
+	APIAddr(ctx context.Context, in *APIAddrRequest, opts ...grpc.CallOption) (*APIAddrReply, error)
+	GetConfig(ctx context.Context, in *GetConfigRequest, opts ...grpc.CallOption) (*GetConfigReply, error)
+	WriteConfig(ctx context.Context, in *WriteConfigRequest, opts ...grpc.CallOption) (*WriteConfigReply, error)
+}
+
+type Agent_EventsClient interface {
+	Recv() (*Event, error)
+	grpc.ClientStream
+}
+
+type agentClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewAgentClient(cc grpc.ClientConnInterface) AgentClient {
+	return &agentClient{cc}
+}
+
+func (c *agentClient) Init(ctx context.Context, in *InitRequest, opts ...grpc.CallOption) (*InitReply, error) {
+	out := new(InitReply)
+	if err := c.cc.Invoke(ctx, "/agent.Agent/Init", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *agentClient) Start(ctx context.Context, in *StartRequest, opts ...grpc.CallOption) (*StartReply, error) {
+	out := new(StartReply)
+	if err := c.cc.Invoke(ctx, "/agent.Agent/Start", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *agentClient) Kill(ctx context.Context, in *KillRequest, opts ...grpc.CallOption) (*KillReply, error) {
+	out := new(KillReply)
+	if err := c.cc.Invoke(ctx, "/agent.Agent/Kill", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *agentClient) RunCmd(ctx context.Context, in *RunCmdRequest, opts ...grpc.CallOption) (*RunCmdReply, error) {
+	out := new(RunCmdReply)
+	if err := c.cc.Invoke(ctx, "/agent.Agent/RunCmd", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *agentClient) Events(ctx context.Context, in *EventsRequest, opts ...grpc.CallOption) (Agent_EventsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Agent_ServiceDesc.Streams[0], "/agent.Agent/Events", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &agentEventsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type agentEventsClient struct {
+	grpc.ClientStream
+}
+
+func (x *agentEventsClient) Recv() (*Event, error) {
+	m := new(Event)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *agentClient) APIAddr(ctx context.Context, in *APIAddrRequest, opts ...grpc.CallOption) (*APIAddrReply, error) {
+	out := new(APIAddrReply)
+	if err := c.cc.Invoke(ctx, "/agent.Agent/APIAddr", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *agentClient) GetConfig(ctx context.Context, in *GetConfigRequest, opts ...grpc.CallOption) (*GetConfigReply, error) {
+	out := new(GetConfigReply)
+	if err := c.cc.Invoke(ctx, "/agent.Agent/GetConfig", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *agentClient) WriteConfig(ctx context.Context, in *WriteConfigRequest, opts ...grpc.CallOption) (*WriteConfigReply, error) {
+	out := new(WriteConfigReply)
+	if err := c.cc.Invoke(ctx, "/agent.Agent/WriteConfig", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AgentServer is the server API for the Agent service.
+type AgentServer interface {
+	Init(context.Context, *InitRequest) (*InitReply, error)
+	Start(context.Context, *StartRequest) (*StartReply, error)
+	Kill(context.Context, *KillRequest) (*KillReply, error)
+	RunCmd(context.Context, *RunCmdRequest) (*RunCmdReply, error)
+	State(context.Context, *StateRequest) (*StateReply, error)
+	Events(*EventsRequest, Agent_EventsServer) error
+	APIAddr(context.Context, *APIAddrRequest) (*APIAddrReply, error)
+	GetConfig(context.Context, *GetConfigRequest) (*GetConfigReply, error)
+	WriteConfig(context.Context, *WriteConfigRequest) (*WriteConfigReply, error)
+}
+
+type Agent_EventsServer interface {
+	Send(*Event) error
+	grpc.ServerStream
+}
+
+func RegisterAgentServer(s grpc.ServiceRegistrar, srv AgentServer) {
+	s.RegisterService(&Agent_ServiceDesc, srv)
+}
+
+func _Agent_Init_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(InitRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AgentServer).Init(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/agent.Agent/Init"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AgentServer).Init(ctx, req.(*InitRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Agent_Start_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StartRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AgentServer).Start(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/agent.Agent/Start"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AgentServer).Start(ctx, req.(*StartRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Agent_Kill_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(KillRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AgentServer).Kill(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/agent.Agent/Kill"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AgentServer).Kill(ctx, req.(*KillRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Agent_State_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AgentServer).State(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/agent.Agent/State"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AgentServer).State(ctx, req.(*StateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Agent_APIAddr_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(APIAddrRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AgentServer).APIAddr(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/agent.Agent/APIAddr"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AgentServer).APIAddr(ctx, req.(*APIAddrRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Agent_GetConfig_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetConfigRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AgentServer).GetConfig(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/agent.Agent/GetConfig"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AgentServer).GetConfig(ctx, req.(*GetConfigRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Agent_WriteConfig_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(WriteConfigRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AgentServer).WriteConfig(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/agent.Agent/WriteConfig"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AgentServer).WriteConfig(ctx, req.(*WriteConfigRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Agent_RunCmd_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RunCmdRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AgentServer).RunCmd(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/agent.Agent/RunCmd"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AgentServer).RunCmd(ctx, req.(*RunCmdRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Agent_Events_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(EventsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(AgentServer).Events(m, &agentEventsServer{stream})
+}
+
+type agentEventsServer struct {
+	grpc.ServerStream
+}
+
+func (x *agentEventsServer) Send(m *Event) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+var Agent_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "agent.Agent",
+	HandlerType: (*AgentServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "Init", Handler: _Agent_Init_Handler},
+		{MethodName: "Start", Handler: _Agent_Start_Handler},
+		{MethodName: "Kill", Handler: _Agent_Kill_Handler},
+		{MethodName: "RunCmd", Handler: _Agent_RunCmd_Handler},
+		{MethodName: "State", Handler: _Agent_State_Handler},
+		{MethodName: "APIAddr", Handler: _Agent_APIAddr_Handler},
+		{MethodName: "GetConfig", Handler: _Agent_GetConfig_Handler},
+		{MethodName: "WriteConfig", Handler: _Agent_WriteConfig_Handler},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Events",
+			Handler:       _Agent_Events_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "agent.proto",
+}
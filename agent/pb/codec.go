@@ -0,0 +1,33 @@
+package pb
+
+import (
+	"encoding/json"
+
+	"google.golang.org/grpc/encoding"
+)
+
+// jsonCodec replaces grpc-go's default "proto" codec with one that
+// marshals messages as JSON. The message types in this package are
+// plain structs, not generated proto.Message implementations, so the
+// real protobuf codec's `v.(proto.Message)` assertion would fail for
+// every RPC; registering under the "proto" name makes this codec the
+// one grpc-go selects whenever a call doesn't ask for a different
+// content-subtype, with no other wiring required on the client or
+// server.
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func (jsonCodec) Name() string {
+	return "proto"
+}
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
@@ -0,0 +1,227 @@
+// Package agent implements the server side of the iptb-agent gRPC
+// protocol: a thin wrapper that exposes a single util.TestbedNode over
+// the network so that util.RemoteNode can drive it from another host.
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/casebell/iptb/agent/pb"
+	iptbutil "github.com/casebell/iptb/util"
+	"github.com/ipfs/go-ipfs/repo/config"
+)
+
+var _ pb.AgentServer = (*Server)(nil)
+
+// Server adapts a local util.TestbedNode to the Agent gRPC service.
+type Server struct {
+	node iptbutil.TestbedNode
+}
+
+// New wraps node for serving over gRPC. node is typically a
+// *iptbutil.LocalNode running on the same host as the agent process.
+func New(node iptbutil.TestbedNode) *Server {
+	return &Server{node: node}
+}
+
+func (s *Server) Init(ctx context.Context, req *pb.InitRequest) (*pb.InitReply, error) {
+	if err := s.node.Init(); err != nil {
+		return nil, err
+	}
+	return &pb.InitReply{}, nil
+}
+
+func (s *Server) Start(ctx context.Context, req *pb.StartRequest) (*pb.StartReply, error) {
+	if err := s.node.Start(req.Args); err != nil {
+		return nil, err
+	}
+	return &pb.StartReply{PeerId: s.node.GetPeerID()}, nil
+}
+
+func (s *Server) Kill(ctx context.Context, req *pb.KillRequest) (*pb.KillReply, error) {
+	if err := s.node.Kill(); err != nil {
+		return nil, err
+	}
+	return &pb.KillReply{}, nil
+}
+
+func (s *Server) RunCmd(ctx context.Context, req *pb.RunCmdRequest) (*pb.RunCmdReply, error) {
+	out, err := s.node.RunCmd(req.Args...)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.RunCmdReply{Output: out}, nil
+}
+
+func (s *Server) State(ctx context.Context, req *pb.StateRequest) (*pb.StateReply, error) {
+	_, err := s.node.APIAddr()
+	return &pb.StateReply{Running: err == nil}, nil
+}
+
+// logReader is implemented by node types (currently *iptbutil.LocalNode)
+// that buffer their daemon's stdout/stderr to disk.
+type logReader interface {
+	StdoutReader() (io.ReadCloser, error)
+	StderrReader() (io.ReadCloser, error)
+}
+
+// Events merges the wrapped node's lifecycle events (from its
+// TestbedNode.Events channel) with its raw stdout and stderr, if it
+// supports log tailing, and streams all three back as Event messages.
+// This is what lets `iptb logs -f` and `iptb events -f` follow a daemon
+// running behind a remote agent.
+func (s *Server) Events(req *pb.EventsRequest, stream pb.Agent_EventsServer) error {
+	ctx := stream.Context()
+
+	lifecycle, err := s.node.Events(ctx)
+	if err != nil {
+		return err
+	}
+
+	output := make(chan *pb.Event)
+	if lr, ok := s.node.(logReader); ok {
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() { defer wg.Done(); tailOutput(ctx, lr.StdoutReader, pb.Output_STDOUT, output) }()
+		go func() { defer wg.Done(); tailOutput(ctx, lr.StderrReader, pb.Output_STDERR, output) }()
+		go func() { wg.Wait(); close(output) }()
+	} else {
+		close(output)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case ev, ok := <-lifecycle:
+			if !ok {
+				lifecycle = nil
+				continue
+			}
+			if err := stream.Send(toWireEvent(ev)); err != nil {
+				return err
+			}
+		case ev, ok := <-output:
+			if !ok {
+				output = nil
+				continue
+			}
+			if err := stream.Send(ev); err != nil {
+				return err
+			}
+		}
+
+		if lifecycle == nil && output == nil {
+			return nil
+		}
+	}
+}
+
+func toWireEvent(ev iptbutil.NodeEvent) *pb.Event {
+	var kind pb.Event_Kind
+	switch ev.Kind {
+	case iptbutil.EventStarted:
+		kind = pb.Event_STARTED
+	case iptbutil.EventDied:
+		kind = pb.Event_DIED
+	case iptbutil.EventRestarted:
+		kind = pb.Event_RESTARTED
+	default:
+		kind = pb.Event_SAMPLE
+	}
+
+	out := &pb.Event{Kind: kind, UnixNano: ev.Time.UnixNano()}
+	if kind == pb.Event_SAMPLE {
+		out.Sample = &pb.Sample{
+			BwIn:          ev.Sample.BWIn,
+			BwOut:         ev.Sample.BWOut,
+			CpuUsage:      ev.Sample.CPUUsage,
+			MemoryCurrent: ev.Sample.MemoryCurrent,
+			RepoSize:      ev.Sample.RepoSize,
+			PeerCount:     int64(ev.Sample.PeerCount),
+		}
+	}
+	return out
+}
+
+// tailPollInterval bounds how often tailOutput retries a Read after
+// hitting EOF. lr's reader is a plain file, not a pipe, so an EOF never
+// blocks the way it would on a daemon's live stdout; without a pause
+// between retries the loop would busy-spin a full core for as long as
+// the log stream is open.
+const tailPollInterval = 100 * time.Millisecond
+
+// tailOutput streams a node's log file, opened by open (StdoutReader or
+// StderrReader), to out as OUTPUT-kind events tagged with stream, until
+// ctx is canceled.
+func tailOutput(ctx context.Context, open func() (io.ReadCloser, error), stream pb.Output_Stream, out chan<- *pb.Event) {
+	r, err := open()
+	if err != nil {
+		return
+	}
+	defer r.Close()
+
+	buf := make([]byte, 4096)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			ev := &pb.Event{
+				Kind:   pb.Event_OUTPUT,
+				Output: &pb.Output{Stream: stream, Data: append([]byte(nil), buf[:n]...)},
+			}
+			select {
+			case out <- ev:
+			case <-ctx.Done():
+				return
+			}
+		}
+		if err == io.EOF {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(tailPollInterval):
+				continue
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (s *Server) APIAddr(ctx context.Context, req *pb.APIAddrRequest) (*pb.APIAddrReply, error) {
+	addr, err := s.node.APIAddr()
+	if err != nil {
+		return nil, err
+	}
+	return &pb.APIAddrReply{Addr: addr}, nil
+}
+
+func (s *Server) GetConfig(ctx context.Context, req *pb.GetConfigRequest) (*pb.GetConfigReply, error) {
+	cfg, err := s.node.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.GetConfigReply{ConfigJson: b}, nil
+}
+
+func (s *Server) WriteConfig(ctx context.Context, req *pb.WriteConfigRequest) (*pb.WriteConfigReply, error) {
+	cfg := new(config.Config)
+	if err := json.Unmarshal(req.ConfigJson, cfg); err != nil {
+		return nil, err
+	}
+
+	if err := s.node.WriteConfig(cfg); err != nil {
+		return nil, err
+	}
+	return &pb.WriteConfigReply{}, nil
+}
@@ -0,0 +1,319 @@
+// +build linux
+
+package iptbutil
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const cgroupRoot = "/sys/fs/cgroup/iptb"
+
+// cgroupIsV2 is true when the host uses the unified cgroup v2
+// hierarchy. iptb prefers v2 and falls back to v1 otherwise.
+var cgroupIsV2 = func() bool {
+	_, err := os.Stat("/sys/fs/cgroup/cgroup.controllers")
+	return err == nil
+}()
+
+// cgroupPathForDir returns the cgroup iptb uses for the daemon rooted
+// at dir. Nodes are keyed by the base name of their repo directory,
+// which is unique within a testbed.
+func cgroupPathForDir(dir string) string {
+	return filepath.Join(cgroupRoot, filepath.Base(dir))
+}
+
+func cgroupExists(path string) bool {
+	if !cgroupIsV2 {
+		// v1 has no directory at path itself, only one per hierarchy
+		path = v1SubsysPath("freezer", path)
+	}
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// v1Subsystems lists every v1 hierarchy iptb joins a daemon to: cpu,
+// memory and blkio for the cpu.max/memory.max/io.max attributes, plus
+// freezer so killCgroupProcs can actually freeze the cgroup before
+// signaling it.
+var v1Subsystems = []string{"cpu", "memory", "blkio", "freezer"}
+
+// ensureCgroup creates (if necessary) the cgroup for path and, on v1,
+// joins every hierarchy in v1Subsystems.
+func ensureCgroup(path string) error {
+	if cgroupIsV2 {
+		return os.MkdirAll(path, 0755)
+	}
+
+	for _, sub := range v1Subsystems {
+		if err := os.MkdirAll(v1SubsysPath(sub, path), 0755); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func v1SubsysPath(subsys, path string) string {
+	return filepath.Join("/sys/fs/cgroup", subsys, strings.TrimPrefix(path, "/sys/fs/cgroup/"))
+}
+
+// addProcessToCgroup places pid under the cgroup at path.
+func addProcessToCgroup(path string, pid int) error {
+	if cgroupIsV2 {
+		return writeCgroupFile(filepath.Join(path, "cgroup.procs"), strconv.Itoa(pid))
+	}
+
+	for _, sub := range v1Subsystems {
+		if err := writeCgroupFile(filepath.Join(v1SubsysPath(sub, path), "cgroup.procs"), strconv.Itoa(pid)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setCgroupAttr writes one of the attrs this package exposes via
+// SetAttr ("cpu.max", "memory.max", "io.max") into the right cgroup
+// file for the host's cgroup version.
+func setCgroupAttr(path, attr, val string) error {
+	if cgroupIsV2 {
+		return writeCgroupFile(filepath.Join(path, attr), val)
+	}
+
+	switch attr {
+	case "cpu.max":
+		// v2 "cpu.max" is "$MAX $PERIOD"; v1 splits this across two files.
+		fields := strings.Fields(val)
+		if len(fields) != 2 {
+			return fmt.Errorf("cpu.max: expected \"<max> <period>\", got %q", val)
+		}
+		if err := writeCgroupFile(filepath.Join(v1SubsysPath("cpu", path), "cpu.cfs_quota_us"), fields[0]); err != nil {
+			return err
+		}
+		return writeCgroupFile(filepath.Join(v1SubsysPath("cpu", path), "cpu.cfs_period_us"), fields[1])
+	case "memory.max":
+		return writeCgroupFile(filepath.Join(v1SubsysPath("memory", path), "memory.limit_in_bytes"), val)
+	case "io.max":
+		return setV1IOMax(path, val)
+	default:
+		return fmt.Errorf("unrecognized cgroup attribute: %s", attr)
+	}
+}
+
+// v1IOMaxFiles maps the v2 io.max key=value fields iptb accepts to the
+// v1 blkio throttle file that enforces the same limit. Unlike the v2
+// unified "io.max" file, v1 has no single limit-setting file: each of
+// these is keyed by "<major>:<minor> <value>" lines, one device per
+// line.
+var v1IOMaxFiles = map[string]string{
+	"rbps":  "blkio.throttle.read_bps_device",
+	"wbps":  "blkio.throttle.write_bps_device",
+	"riops": "blkio.throttle.read_iops_device",
+	"wiops": "blkio.throttle.write_iops_device",
+}
+
+// setV1IOMax applies an io.max value formatted like v2's io.max file,
+// "<major>:<minor> rbps=<n> wbps=<n> riops=<n> wiops=<n>" (any subset
+// of the four keys), to the v1 blkio throttle files that actually
+// enforce limits (blkio.throttle.io_service_bytes, which getCgroupAttr
+// reads for "io.stat", is read-only accounting and cannot be written).
+func setV1IOMax(path, val string) error {
+	fields := strings.Fields(val)
+	if len(fields) < 2 {
+		return fmt.Errorf("io.max: expected \"<major>:<minor> rbps=<n> ...\", got %q", val)
+	}
+
+	dev := fields[0]
+	for _, kv := range fields[1:] {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("io.max: malformed limit %q", kv)
+		}
+
+		file, ok := v1IOMaxFiles[parts[0]]
+		if !ok {
+			return fmt.Errorf("io.max: unrecognized limit %q", parts[0])
+		}
+
+		if err := writeCgroupFile(filepath.Join(v1SubsysPath("blkio", path), file), dev+" "+parts[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getCgroupAttr reads one of "cpu.usage", "memory.current", "io.stat"
+// back out of the cgroup at path. "cpu.usage" is in microseconds on
+// both cgroup versions.
+func getCgroupAttr(path, attr string) (string, error) {
+	if cgroupIsV2 {
+		switch attr {
+		case "cpu.usage":
+			return readCgroupStatField(filepath.Join(path, "cpu.stat"), "usage_usec")
+		case "memory.current":
+			return readCgroupFile(filepath.Join(path, "memory.current"))
+		case "io.stat":
+			return readCgroupFile(filepath.Join(path, "io.stat"))
+		}
+		return "", fmt.Errorf("unrecognized cgroup attribute: %s", attr)
+	}
+
+	switch attr {
+	case "cpu.usage":
+		// cpuacct.usage counts nanoseconds; v2's usage_usec counts microseconds
+		raw, err := readCgroupFile(filepath.Join(v1SubsysPath("cpu", path), "cpuacct.usage"))
+		if err != nil {
+			return "", err
+		}
+		ns, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			return "", err
+		}
+		return strconv.FormatUint(ns/1000, 10), nil
+	case "memory.current":
+		return readCgroupFile(filepath.Join(v1SubsysPath("memory", path), "memory.usage_in_bytes"))
+	case "io.stat":
+		return readCgroupFile(filepath.Join(v1SubsysPath("blkio", path), "blkio.throttle.io_service_bytes"))
+	}
+	return "", fmt.Errorf("unrecognized cgroup attribute: %s", attr)
+}
+
+func readCgroupStatField(path, field string) (string, error) {
+	raw, err := readCgroupFile(path)
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(raw, "\n") {
+		parts := strings.Fields(line)
+		if len(parts) == 2 && parts[0] == field {
+			return parts[1], nil
+		}
+	}
+	return "", fmt.Errorf("%s: field %q not found", path, field)
+}
+
+func readCgroupFile(path string) (string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+func writeCgroupFile(path, val string) error {
+	return ioutil.WriteFile(path, []byte(val), 0644)
+}
+
+// killCgroupProcs stops every process in the cgroup rooted at path:
+// freeze so nothing can fork its way out, SIGTERM everyone, escalate to
+// SIGKILL for stragglers, then remove the cgroup. This fixes the
+// pid-file-only shutdown path losing track of a daemon's grandchildren.
+func killCgroupProcs(path, pidFileDir string) error {
+	if err := freeze(path, true); err != nil {
+		return fmt.Errorf("freezing cgroup %s: %s", path, err)
+	}
+
+	pids, err := cgroupPids(path)
+	if err != nil {
+		return err
+	}
+
+	signalAll(pids, syscall.SIGTERM)
+
+	// thaw so the signaled processes can handle SIGTERM and exit
+	if err := freeze(path, false); err != nil {
+		return fmt.Errorf("thawing cgroup %s: %s", path, err)
+	}
+	if waitAllExited(pids, 2*time.Second) {
+		return rmCgroup(path, pidFileDir)
+	}
+
+	signalAll(pids, syscall.SIGKILL)
+	waitAllExited(pids, 2*time.Second)
+
+	return rmCgroup(path, pidFileDir)
+}
+
+func cgroupPids(path string) ([]int, error) {
+	procsFile := filepath.Join(path, "cgroup.procs")
+	if !cgroupIsV2 {
+		procsFile = filepath.Join(v1SubsysPath("cpu", path), "cgroup.procs")
+	}
+
+	raw, err := ioutil.ReadFile(procsFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var pids []int
+	for _, line := range strings.Split(strings.TrimSpace(string(raw)), "\n") {
+		if line == "" {
+			continue
+		}
+		pid, err := strconv.Atoi(line)
+		if err != nil {
+			continue
+		}
+		pids = append(pids, pid)
+	}
+	return pids, nil
+}
+
+func freeze(path string, frozen bool) error {
+	if cgroupIsV2 {
+		val := "0"
+		if frozen {
+			val = "1"
+		}
+		return writeCgroupFile(filepath.Join(path, "cgroup.freeze"), val)
+	}
+
+	state := "THAWED"
+	if frozen {
+		state = "FROZEN"
+	}
+	return writeCgroupFile(filepath.Join(v1SubsysPath("freezer", path), "freezer.state"), state)
+}
+
+func signalAll(pids []int, sig syscall.Signal) {
+	for _, pid := range pids {
+		syscall.Kill(pid, sig)
+	}
+}
+
+func waitAllExited(pids []int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		anyAlive := false
+		for _, pid := range pids {
+			if syscall.Kill(pid, 0) == nil {
+				anyAlive = true
+				break
+			}
+		}
+		if !anyAlive {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+func rmCgroup(path, pidFileDir string) error {
+	if cgroupIsV2 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	for _, sub := range []string{"cpu", "memory", "blkio", "freezer"} {
+		os.Remove(v1SubsysPath(sub, path))
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+// +build !linux
+
+package iptbutil
+
+import "errors"
+
+// Cgroups are a Linux-only concept; on other platforms iptb falls back
+// to the plain pid-based Start/Kill path.
+
+func cgroupPathForDir(dir string) string { return "" }
+
+func cgroupExists(path string) bool { return false }
+
+func ensureCgroup(path string) error { return nil }
+
+func addProcessToCgroup(path string, pid int) error { return nil }
+
+func setCgroupAttr(path, attr, val string) error {
+	return errors.New("cgroup resource limits are only supported on linux")
+}
+
+func getCgroupAttr(path, attr string) (string, error) {
+	return "", errors.New("cgroup resource usage is only supported on linux")
+}
+
+func killCgroupProcs(path, pidFileDir string) error {
+	return errors.New("cgroup-based shutdown is only supported on linux")
+}
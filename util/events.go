@@ -0,0 +1,200 @@
+package iptbutil
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// parseUintOrZero is a small convenience for reading best-effort
+// numeric stats out of sysfs/cgroup files: a missing or malformed value
+// just reports as zero rather than failing the whole sample.
+func parseUintOrZero(s string) uint64 {
+	n, _ := strconv.ParseUint(s, 10, 64)
+	return n
+}
+
+// dirSize sums the size of every regular file under dir, for the
+// NodeSample.RepoSize field. It makes a best effort: a file that
+// disappears mid-walk (e.g. a log being rotated) is simply skipped
+// rather than failing the whole sample.
+func dirSize(dir string) uint64 {
+	var total uint64
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if !info.IsDir() {
+			total += uint64(info.Size())
+		}
+		return nil
+	})
+	return total
+}
+
+// EventKind classifies a NodeEvent.
+type EventKind int
+
+const (
+	// EventStarted fires once, the first time a node is observed running.
+	EventStarted EventKind = iota
+	// EventDied fires when a previously-running node is seen stopped,
+	// whether it crashed or was killed.
+	EventDied
+	// EventSample carries a periodic resource snapshot of a running node.
+	EventSample
+	// EventRestarted fires when a node starts again after an EventDied.
+	EventRestarted
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case EventStarted:
+		return "started"
+	case EventDied:
+		return "died"
+	case EventSample:
+		return "sample"
+	case EventRestarted:
+		return "restarted"
+	default:
+		return "unknown"
+	}
+}
+
+// NodeEvent is one entry in a node's event stream: a lifecycle
+// transition, or a periodic resource sample.
+type NodeEvent struct {
+	PeerID string
+	Kind   EventKind
+	Time   time.Time
+
+	// Sample is only populated for EventSample events.
+	Sample NodeSample
+}
+
+// NodeSample is a point-in-time snapshot of a node's resource usage, as
+// reported by GetAttr's bw_in/bw_out/cpu.usage/memory.current keys
+// where the backend supports them.
+type NodeSample struct {
+	BWIn, BWOut   uint64
+	CPUUsage      uint64
+	MemoryCurrent uint64
+	RepoSize      uint64
+	PeerCount     int
+}
+
+// nodeSyncLimit bounds how often a node emits EventSample while
+// running, the same debounced-sync idea as a scheduler's periodic
+// task-state sync limit: short-lived blips shouldn't spam consumers
+// with an update every tick.
+const nodeSyncLimit = 30 * time.Second
+
+// ringBuffer is a fixed-capacity FIFO of NodeEvents. Once full, pushing
+// a new event evicts the oldest one.
+type ringBuffer struct {
+	mu     sync.Mutex
+	buf    []NodeEvent
+	head   int
+	filled bool
+}
+
+func newRingBuffer(capacity int) *ringBuffer {
+	return &ringBuffer{buf: make([]NodeEvent, capacity)}
+}
+
+func (r *ringBuffer) push(ev NodeEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.buf[r.head] = ev
+	r.head = (r.head + 1) % len(r.buf)
+	if r.head == 0 {
+		r.filled = true
+	}
+}
+
+// snapshot returns the buffered events in the order they were pushed.
+func (r *ringBuffer) snapshot() []NodeEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.filled {
+		out := make([]NodeEvent, r.head)
+		copy(out, r.buf[:r.head])
+		return out
+	}
+
+	out := make([]NodeEvent, len(r.buf))
+	copy(out, r.buf[r.head:])
+	copy(out[len(r.buf)-r.head:], r.buf[:r.head])
+	return out
+}
+
+// pollLifecycle is the shared Events() implementation for node types
+// whose liveness is a pid file on disk (LocalNode, FilecoinNode): it
+// polls isAlive(dir) and emits Started/Died/Restarted transitions plus
+// a debounced Sample while running. sample is called to fill in each
+// Sample event's NodeSample; it may return the zero value if a backend
+// doesn't support some of the fields.
+func pollLifecycle(ctx context.Context, dir string, peerID func() string, sample func() NodeSample) (<-chan NodeEvent, error) {
+	out := make(chan NodeEvent)
+
+	go func() {
+		defer close(out)
+
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+
+		wasAlive := false
+		everStarted := false
+		lastSample := time.Time{}
+
+		emit := func(kind EventKind) {
+			ev := NodeEvent{PeerID: peerID(), Kind: kind, Time: time.Now()}
+			if kind == EventSample {
+				ev.Sample = sample()
+			}
+			select {
+			case out <- ev:
+			case <-ctx.Done():
+			}
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			alive, err := isAlive(dir)
+			if err != nil {
+				continue
+			}
+
+			switch {
+			case alive && !wasAlive:
+				if !everStarted {
+					emit(EventStarted)
+					everStarted = true
+				} else {
+					emit(EventRestarted)
+				}
+				lastSample = time.Now()
+			case !alive && wasAlive:
+				emit(EventDied)
+			case alive && time.Since(lastSample) >= nodeSyncLimit:
+				emit(EventSample)
+				lastSample = time.Now()
+			}
+
+			wasAlive = alive
+		}
+	}()
+
+	return out, nil
+}
@@ -1,6 +1,7 @@
 package iptbutil
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"strings"
@@ -140,3 +141,12 @@ func (fn *FilecoinNode) Shell() error {
 func (fn *FilecoinNode) BinName() string {
 	return "go-filecoin"
 }
+
+// Events samples what's cheaply available for a FilecoinNode: its repo
+// size. Bandwidth, cgroup and peer-count plumbing are only wired up for
+// the ipfs-backed node types, so those fields stay zero here.
+func (fn *FilecoinNode) Events(ctx context.Context) (<-chan NodeEvent, error) {
+	return pollLifecycle(ctx, fn.Dir, fn.GetPeerID, func() NodeSample {
+		return NodeSample{RepoSize: dirSize(fn.Dir)}
+	})
+}
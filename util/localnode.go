@@ -2,6 +2,7 @@ package iptbutil
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -24,6 +25,8 @@ import (
 type LocalNode struct {
 	Dir    string
 	PeerID string
+
+	netShaped bool
 }
 
 func (n *LocalNode) Init() error {
@@ -201,12 +204,21 @@ func startProcess(bin string, dcmd string, args []string, dir string, env []stri
 	cmd.Stdout = stdout
 	cmd.Stderr = stderr
 
+	cgPath := cgroupPathForDir(dir)
+	if err := ensureCgroup(cgPath); err != nil {
+		return fmt.Errorf("creating cgroup for %s: %s", dir, err)
+	}
+
 	err = cmd.Start()
 	if err != nil {
 		return err
 	}
 	pid := cmd.Process.Pid
 
+	if err := addProcessToCgroup(cgPath, pid); err != nil {
+		return fmt.Errorf("placing daemon %s in cgroup: %s", dir, err)
+	}
+
 	fmt.Printf("Started daemon %s, pid = %d\n", dir, pid)
 	err = ioutil.WriteFile(filepath.Join(dir, "daemon.pid"), []byte(fmt.Sprint(pid)), 0666)
 	if err != nil {
@@ -251,10 +263,23 @@ func (n *LocalNode) Kill() error {
 		return fmt.Errorf("error killing daemon %s: %s", n.Dir, err)
 	}
 
+	if n.netShaped {
+		if err := removeNodeNetem(n.Dir); err != nil {
+			return err
+		}
+		n.netShaped = false
+	}
+
 	return killPid(pid, n.Dir)
 }
 
 func killPid(pid int, dir string) error {
+	cgPath := cgroupPathForDir(dir)
+	if cgroupExists(cgPath) {
+		defer os.Remove(filepath.Join(dir, "daemon.pid"))
+		return killCgroupProcs(cgPath, dir)
+	}
+
 	p, err := os.FindProcess(pid)
 	if err != nil {
 		return fmt.Errorf("error killing daemon %s: %s", dir, err)
@@ -342,6 +367,8 @@ func (n *LocalNode) GetAttr(attr string) (string, error) {
 			return "", err
 		}
 		return fmt.Sprint(bw.TotalOut), nil
+	case "cpu.usage", "memory.current", "io.stat":
+		return getCgroupAttr(cgroupPathForDir(n.Dir), attr)
 	default:
 		return "", errors.New("unrecognized attribute: " + attr)
 	}
@@ -355,8 +382,69 @@ func (n *LocalNode) WriteConfig(c *config.Config) error {
 	return serial.WriteConfigFile(filepath.Join(n.Dir, "config"), c)
 }
 
+// SetAttr shapes the network the daemon sees, or adjusts the resource
+// limits of the cgroup it runs in. "latency" and "bandwidth" set the
+// default network shape applied to the daemon's loopback traffic;
+// "link:<peerid>" is accepted for interface compatibility with the
+// mocknet backend, but the peer id is ignored: its shape applies to all
+// of this node's swarm traffic, not to the link with that one peer.
+// Every local daemon shares the host's loopback, so shaping is scoped to
+// this node's swarm port (see applyNodeNetem) rather than the whole
+// interface.
+// "cpu.max", "memory.max" and "io.max" write straight into the
+// corresponding cgroup control file.
 func (n *LocalNode) SetAttr(name, val string) error {
-	return fmt.Errorf("no atttributes to set")
+	switch name {
+	case "cpu.max", "memory.max", "io.max":
+		return setCgroupAttr(cgroupPathForDir(n.Dir), name, val)
+	}
+
+	var shape LinkShape
+	var err error
+
+	switch {
+	case name == "latency":
+		shape.Latency, err = time.ParseDuration(val)
+	case name == "bandwidth":
+		shape.Bandwidth, err = parseBandwidth(val)
+	case strings.HasPrefix(name, "link:"):
+		shape, err = parseLinkShape(val)
+	default:
+		return fmt.Errorf("no such attribute: %s", name)
+	}
+	if err != nil {
+		return err
+	}
+
+	port, err := n.swarmPort()
+	if err != nil {
+		return fmt.Errorf("network shaping requires a configured node: %s", err)
+	}
+
+	if err := applyNodeNetem(n.Dir, port, shape); err != nil {
+		return err
+	}
+	n.netShaped = true
+	return nil
+}
+
+// swarmPort returns the TCP port this node's daemon listens for swarm
+// connections on, used to scope tc filters to just this node's traffic.
+func (n *LocalNode) swarmPort() (string, error) {
+	cfg, err := n.GetConfig()
+	if err != nil {
+		return "", err
+	}
+	if len(cfg.Addresses.Swarm) == 0 {
+		return "", errors.New("node has no swarm address configured")
+	}
+
+	maddr, err := ma.NewMultiaddr(cfg.Addresses.Swarm[0])
+	if err != nil {
+		return "", err
+	}
+
+	return maddr.ValueForProtocol(ma.P_TCP)
 }
 
 func (n *LocalNode) StdoutReader() (io.ReadCloser, error) {
@@ -375,3 +463,24 @@ func (n *LocalNode) readerFor(file string) (io.ReadCloser, error) {
 func (n *LocalNode) BinName() string {
 	return "ipfs"
 }
+
+// Events streams this node's lifecycle transitions and periodic
+// resource samples, pulling the latter from the same bandwidth and
+// cgroup attrs GetAttr exposes.
+func (n *LocalNode) Events(ctx context.Context) (<-chan NodeEvent, error) {
+	return pollLifecycle(ctx, n.Dir, n.GetPeerID, func() NodeSample {
+		var s NodeSample
+		if bw, err := GetBW(n); err == nil {
+			s.BWIn = bw.TotalIn
+			s.BWOut = bw.TotalOut
+		}
+		if v, err := getCgroupAttr(cgroupPathForDir(n.Dir), "memory.current"); err == nil {
+			s.MemoryCurrent = parseUintOrZero(v)
+		}
+		if v, err := getCgroupAttr(cgroupPathForDir(n.Dir), "cpu.usage"); err == nil {
+			s.CPUUsage = parseUintOrZero(v)
+		}
+		s.RepoSize = dirSize(n.Dir)
+		return s
+	})
+}
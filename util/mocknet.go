@@ -0,0 +1,314 @@
+package iptbutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/ipfs/go-ipfs/core"
+	"github.com/ipfs/go-ipfs/core/coreapi"
+	mock "github.com/ipfs/go-ipfs/core/mock"
+	"github.com/ipfs/go-ipfs/repo/config"
+	"github.com/ipfs/go-ipfs/repo/fsrepo"
+
+	coreiface "github.com/ipfs/interface-go-ipfs-core"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+)
+
+var _ TestbedNode = (*MocknetNode)(nil)
+
+// MocknetNode is a TestbedNode that runs its IPFS instance in-process,
+// wired up over a shared mocknet.Mocknet instead of the real network
+// stack. It never forks an `ipfs daemon` subprocess, which makes it
+// possible to bring up testbeds of hundreds of nodes quickly and
+// deterministically, at the cost of not exercising the real transport.
+type MocknetNode struct {
+	Dir    string
+	PeerID string
+
+	mn   mocknet.Mocknet
+	node *core.IpfsNode
+	api  coreiface.CoreAPI
+}
+
+// NewMocknetNode constructs a MocknetNode that will join the given
+// mocknet when started. Every node of a testbed must share the same
+// mocknet so that LinkAllMocknet (called once all nodes are up) can
+// connect them to each other.
+func NewMocknetNode(dir string, mn mocknet.Mocknet) *MocknetNode {
+	return &MocknetNode{
+		Dir: dir,
+		mn:  mn,
+	}
+}
+
+// LinkAllMocknet links every peer in the mocknet to every other peer. It
+// should be called once, after every node of the testbed has started.
+func LinkAllMocknet(mn mocknet.Mocknet) error {
+	return mn.LinkAll()
+}
+
+func (n *MocknetNode) Init() error {
+	conf, err := config.Init(ioutil.Discard, 2048)
+	if err != nil {
+		return err
+	}
+
+	return fsrepo.Init(n.Dir, conf)
+}
+
+func (n *MocknetNode) Start(args []string) error {
+	if n.mn == nil {
+		return errors.New("mocknet node has no mocknet to join")
+	}
+
+	r, err := fsrepo.Open(n.Dir)
+	if err != nil {
+		return err
+	}
+
+	cfg := &core.BuildCfg{
+		Online: true,
+		Repo:   r,
+		Host:   mock.MockHostOption(n.mn),
+	}
+
+	nd, err := core.NewNode(context.Background(), cfg)
+	if err != nil {
+		return err
+	}
+
+	api, err := coreapi.NewCoreAPI(nd)
+	if err != nil {
+		nd.Close()
+		return err
+	}
+
+	n.node = nd
+	n.api = api
+	n.PeerID = nd.Identity.Pretty()
+
+	return nil
+}
+
+func (n *MocknetNode) Kill() error {
+	if n.node == nil {
+		return errors.New("mocknet node is not running")
+	}
+
+	err := n.node.Close()
+	n.node = nil
+	n.api = nil
+	return err
+}
+
+// RunCmd dispatches a small subset of `ipfs` subcommands directly to the
+// in-process CoreAPI instead of exec'ing a binary. Only `id` and
+// `swarm peers` are handled; anything else, `swarm connect` included, is
+// rejected rather than silently ignored.
+func (n *MocknetNode) RunCmd(args ...string) (string, error) {
+	if n.api == nil {
+		return "", errors.New("mocknet node is not running")
+	}
+
+	if len(args) == 0 {
+		return "", errors.New("no command given")
+	}
+
+	// Allow callers to pass either "ipfs id ..." or "id ..."
+	if args[0] == "ipfs" {
+		args = args[1:]
+	}
+
+	if len(args) == 0 {
+		return "", errors.New("no command given")
+	}
+
+	switch args[0] {
+	case "id":
+		// The CoreAPI has no identity endpoint to route this through, so
+		// this returns just the node's own PeerID (not the JSON object
+		// `ipfs id` prints); any further arguments are ignored.
+		return n.PeerID, nil
+	case "swarm":
+		if len(args) < 2 {
+			return "", errors.New("swarm: missing subcommand")
+		}
+		switch args[1] {
+		case "peers":
+			conns, err := n.api.Swarm().Peers(context.Background())
+			if err != nil {
+				return "", err
+			}
+			peers := make([]string, 0, len(conns))
+			for _, c := range conns {
+				peers = append(peers, c.ID().Pretty())
+			}
+			return strings.Join(peers, "\n"), nil
+		default:
+			return "", fmt.Errorf("swarm %s: not supported on mocknet nodes", args[1])
+		}
+	default:
+		return "", fmt.Errorf("%s: not supported on mocknet nodes", args[0])
+	}
+}
+
+func (n *MocknetNode) APIAddr() (string, error) {
+	if n.node == nil {
+		return "", errors.New("mocknet node is not running")
+	}
+	return fmt.Sprintf("mocknet:%s", n.PeerID), nil
+}
+
+func (n *MocknetNode) GetPeerID() string {
+	return n.PeerID
+}
+
+func (n *MocknetNode) String() string {
+	return n.PeerID
+}
+
+func (n *MocknetNode) Shell() error {
+	return errors.New("shell is not supported for in-process mocknet nodes")
+}
+
+func (n *MocknetNode) BinName() string {
+	return "mocknet"
+}
+
+func (n *MocknetNode) GetAttr(attr string) (string, error) {
+	switch attr {
+	case attrId:
+		return n.GetPeerID(), nil
+	case attrPath:
+		return n.Dir, nil
+	default:
+		return "", errors.New("unrecognized attribute: " + attr)
+	}
+}
+
+// SetAttr shapes the mocknet links to or from this node. "latency" and
+// "bandwidth" reshape every link the node currently has; "link:<peerid>"
+// reshapes only the link to that peer.
+func (n *MocknetNode) SetAttr(name, val string) error {
+	if n.node == nil {
+		return errors.New("mocknet node is not running")
+	}
+
+	var shape LinkShape
+	var err error
+	var target string // peerid, or "" for every link
+
+	switch {
+	case name == "latency":
+		shape.Latency, err = time.ParseDuration(val)
+	case name == "bandwidth":
+		shape.Bandwidth, err = parseBandwidth(val)
+	case strings.HasPrefix(name, "link:"):
+		shape, err = parseLinkShape(val)
+		target = strings.TrimPrefix(name, "link:")
+	default:
+		return fmt.Errorf("no such attribute: %s", name)
+	}
+	if err != nil {
+		return err
+	}
+
+	opts := toMocknetLinkOptions(shape)
+	self := n.node.Identity
+	for _, p := range n.mn.Peers() {
+		if p == self || (target != "" && p.Pretty() != target) {
+			continue
+		}
+		for _, l := range n.mn.LinksBetweenPeers(self, p) {
+			l.SetOptions(opts)
+		}
+	}
+	return nil
+}
+
+// Events streams this node's lifecycle transitions and periodic peer
+// counts. Since a MocknetNode never forks and never dies on its own, it
+// only ever emits a Started event followed by debounced Samples for as
+// long as ctx (or the caller's Kill) keeps it alive.
+func (n *MocknetNode) Events(ctx context.Context) (<-chan NodeEvent, error) {
+	if n.node == nil {
+		return nil, errors.New("mocknet node is not running")
+	}
+
+	out := make(chan NodeEvent)
+	go func() {
+		defer close(out)
+
+		send := func(kind EventKind) bool {
+			ev := NodeEvent{PeerID: n.PeerID, Kind: kind, Time: time.Now()}
+			if kind == EventSample {
+				nd := n.node
+				if nd == nil {
+					// killed: end the stream
+					return false
+				}
+				ev.Sample = NodeSample{
+					PeerCount: len(nd.PeerHost.Network().Conns()),
+					RepoSize:  dirSize(n.Dir),
+				}
+			}
+			select {
+			case out <- ev:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		if !send(EventStarted) {
+			return
+		}
+
+		ticker := time.NewTicker(nodeSyncLimit)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if !send(EventSample) {
+					return
+				}
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+func (n *MocknetNode) GetConfig() (*config.Config, error) {
+	if n.node != nil {
+		return n.node.Repo.Config()
+	}
+
+	r, err := fsrepo.Open(n.Dir)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return r.Config()
+}
+
+func (n *MocknetNode) WriteConfig(c *config.Config) error {
+	if n.node != nil {
+		return n.node.Repo.SetConfig(c)
+	}
+
+	r, err := fsrepo.Open(n.Dir)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	return r.SetConfig(c)
+}
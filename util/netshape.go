@@ -0,0 +1,217 @@
+package iptbutil
+
+import (
+	"fmt"
+	"hash/fnv"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+)
+
+// netemIface is the interface local-daemon testbeds shape traffic on.
+// Every local node shares the host's loopback device, so shaping can't
+// use a single root qdisc per node the way a dedicated NIC would;
+// instead each node gets its own htb class and netem leaf under one
+// shared root (see ensureShapingRoot/applyNodeNetem), classified by the
+// node's swarm port so that setting or clearing one node's shape never
+// touches another's.
+const netemIface = "lo"
+
+// shapingRootHandle is the shared htb qdisc every shaped node's class
+// hangs off of. It is created once, lazily, and never torn down by a
+// single node's Kill, since other nodes may still be using it.
+const shapingRootHandle = "1:"
+
+// ensureShapingRoot installs the shared root qdisc on iface if it
+// isn't already there. Safe to call repeatedly and concurrently for
+// multiple nodes.
+func ensureShapingRoot(iface string) error {
+	out, err := exec.Command("tc", "qdisc", "add", "dev", iface, "root", "handle", shapingRootHandle, "htb", "default", "fff").CombinedOutput()
+	if err != nil && !strings.Contains(string(out), "File exists") {
+		return fmt.Errorf("tc qdisc add root htb: %s: %s", err, string(out))
+	}
+	return nil
+}
+
+// classIDForNode derives a stable, small htb classid for a node from
+// its repo directory, so the same node always maps to the same class
+// and two different nodes essentially never collide.
+func classIDForNode(dir string) string {
+	h := fnv.New32a()
+	h.Write([]byte(dir))
+	// Keep classids in 1..0xffe; 0xfff is the root's default class.
+	id := (h.Sum32() % 0xffe) + 1
+	return fmt.Sprintf("%x", id)
+}
+
+// LinkShape describes the network conditions iptb should simulate on a
+// link, either the default link for a node or a specific pairwise link
+// to another peer (SetAttr("link:<peerid>", ...)).
+type LinkShape struct {
+	Latency   time.Duration
+	Bandwidth uint64 // bits per second, 0 means unlimited
+	Loss      float64
+}
+
+// parseLinkShape parses the comma-separated key=value form used by
+// SetAttr("link:<peerid>", "latency=100ms,loss=1%"), reusing the same
+// value syntax as the plain "latency"/"bandwidth" attributes.
+func parseLinkShape(val string) (LinkShape, error) {
+	var shape LinkShape
+	for _, kv := range strings.Split(val, ",") {
+		kv = strings.TrimSpace(kv)
+		if kv == "" {
+			continue
+		}
+
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			return shape, fmt.Errorf("malformed link attribute %q", kv)
+		}
+
+		switch parts[0] {
+		case "latency":
+			d, err := time.ParseDuration(parts[1])
+			if err != nil {
+				return shape, fmt.Errorf("latency: %s", err)
+			}
+			shape.Latency = d
+		case "bandwidth":
+			bw, err := parseBandwidth(parts[1])
+			if err != nil {
+				return shape, fmt.Errorf("bandwidth: %s", err)
+			}
+			shape.Bandwidth = bw
+		case "loss":
+			l, err := parsePercent(parts[1])
+			if err != nil {
+				return shape, fmt.Errorf("loss: %s", err)
+			}
+			shape.Loss = l
+		default:
+			return shape, fmt.Errorf("unrecognized link attribute %q", parts[0])
+		}
+	}
+	return shape, nil
+}
+
+// parseBandwidth parses values like "1Mbps", "512Kbps" or a bare number
+// of bits per second.
+func parseBandwidth(s string) (uint64, error) {
+	s = strings.TrimSpace(s)
+
+	var mul uint64 = 1
+	switch {
+	case strings.HasSuffix(s, "Gbps"):
+		mul = 1e9
+		s = strings.TrimSuffix(s, "Gbps")
+	case strings.HasSuffix(s, "Mbps"):
+		mul = 1e6
+		s = strings.TrimSuffix(s, "Mbps")
+	case strings.HasSuffix(s, "Kbps"):
+		mul = 1e3
+		s = strings.TrimSuffix(s, "Kbps")
+	case strings.HasSuffix(s, "bps"):
+		s = strings.TrimSuffix(s, "bps")
+	}
+
+	n, err := strconv.ParseUint(strings.TrimSpace(s), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return n * mul, nil
+}
+
+func parsePercent(s string) (float64, error) {
+	s = strings.TrimSuffix(strings.TrimSpace(s), "%")
+	return strconv.ParseFloat(s, 64)
+}
+
+// applyNodeNetem shapes only the traffic for the local daemon listening
+// on swarmPort, by giving that node its own htb class and netem leaf
+// under the shared root qdisc and a u32 filter that classifies traffic
+// to/from swarmPort into it. Other nodes' classes, filters and shaping
+// are untouched.
+func applyNodeNetem(dir, swarmPort string, shape LinkShape) error {
+	if err := ensureShapingRoot(netemIface); err != nil {
+		return err
+	}
+
+	classID := classIDForNode(dir)
+	classFull := shapingRootHandle + classID
+	rate := "1000mbit"
+	if shape.Bandwidth > 0 {
+		rate = fmt.Sprintf("%dbit", shape.Bandwidth)
+	}
+
+	if out, err := exec.Command("tc", "class", "replace", "dev", netemIface, "parent", shapingRootHandle,
+		"classid", classFull, "htb", "rate", rate).CombinedOutput(); err != nil {
+		return fmt.Errorf("tc class replace: %s: %s", err, string(out))
+	}
+
+	netemArgs := []string{"qdisc", "replace", "dev", netemIface, "parent", classFull, "handle", classID + ":", "netem"}
+	if shape.Latency > 0 {
+		netemArgs = append(netemArgs, "delay", shape.Latency.String())
+	}
+	if shape.Loss > 0 {
+		netemArgs = append(netemArgs, "loss", fmt.Sprintf("%.2f%%", shape.Loss))
+	}
+	if out, err := exec.Command("tc", netemArgs...).CombinedOutput(); err != nil {
+		return fmt.Errorf("tc qdisc replace netem: %s: %s", err, string(out))
+	}
+
+	for _, matchDir := range []string{"dport", "sport"} {
+		filterArgs := []string{"filter", "replace", "dev", netemIface, "protocol", "ip", "parent", shapingRootHandle,
+			"prio", "1", "handle", "::" + classID + boolSuffix(matchDir == "sport"), "u32",
+			"match", "ip", matchDir, swarmPort, "0xffff", "flowid", classFull}
+		if out, err := exec.Command("tc", filterArgs...).CombinedOutput(); err != nil {
+			return fmt.Errorf("tc filter replace (%s): %s: %s", matchDir, err, string(out))
+		}
+	}
+
+	return nil
+}
+
+// boolSuffix disambiguates the two filter handles applyNodeNetem
+// installs for a single node (dport and sport), which otherwise share
+// the same classid-derived handle.
+func boolSuffix(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
+
+// removeNodeNetem tears down the class, netem leaf and filters
+// applyNodeNetem installed for dir, leaving the shared root qdisc (and
+// every other node's class) in place.
+func removeNodeNetem(dir string) error {
+	classID := classIDForNode(dir)
+	classFull := shapingRootHandle + classID
+
+	for _, matchDir := range []string{"dport", "sport"} {
+		handle := "::" + classID + boolSuffix(matchDir == "sport")
+		exec.Command("tc", "filter", "del", "dev", netemIface, "protocol", "ip", "parent", shapingRootHandle,
+			"prio", "1", "handle", handle, "u32").Run()
+	}
+
+	exec.Command("tc", "qdisc", "del", "dev", netemIface, "parent", classFull, "handle", classID+":").Run()
+
+	out, err := exec.Command("tc", "class", "del", "dev", netemIface, "classid", classFull).CombinedOutput()
+	if err != nil && !strings.Contains(string(out), "No such file or directory") {
+		return fmt.Errorf("tc class del: %s: %s", err, string(out))
+	}
+	return nil
+}
+
+// toMocknetLinkOptions converts a LinkShape to the go-libp2p mocknet
+// equivalent used to re-link peers on the in-process backend.
+func toMocknetLinkOptions(shape LinkShape) mocknet.LinkOptions {
+	return mocknet.LinkOptions{
+		Latency:   shape.Latency,
+		Bandwidth: float64(shape.Bandwidth) / 8, // mocknet counts bytes/sec
+	}
+}
@@ -1,6 +1,8 @@
 package iptbutil
 
 import (
+	"context"
+
 	"github.com/ipfs/go-ipfs/repo/config"
 )
 
@@ -20,4 +22,9 @@ type TestbedNode interface {
 
 	GetConfig() (*config.Config, error)
 	WriteConfig(*config.Config) error
+
+	// Events returns a channel of NodeEvents describing this node's
+	// lifecycle and periodic resource samples, for as long as ctx is
+	// not canceled. It backs `iptb events -f` and the StatsReporter.
+	Events(ctx context.Context) (<-chan NodeEvent, error)
 }
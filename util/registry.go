@@ -0,0 +1,118 @@
+package iptbutil
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+)
+
+// NodeSpec is one entry of $IPTB_ROOT/nodespec.json: enough to construct
+// the TestbedNode it describes without the node having to be running
+// yet. Type selects which TestbedNode implementation LoadNodes builds;
+// Addr is only meaningful for "remote" entries.
+type NodeSpec struct {
+	Type string `json:"type"`
+	Dir  string `json:"dir"`
+	Addr string `json:"addr,omitempty"`
+}
+
+// nodeFactories maps a NodeSpec.Type to the TestbedNode constructor it
+// selects. Keeping this as a table rather than a switch buried in
+// LoadNodes is what lets a new backend (e.g. `iptb init --type mocknet`)
+// register itself without the loader changing.
+var nodeFactories = map[string]func(NodeSpec) (TestbedNode, error){
+	"local": func(s NodeSpec) (TestbedNode, error) {
+		return &LocalNode{Dir: s.Dir}, nil
+	},
+	"mocknet": func(s NodeSpec) (TestbedNode, error) {
+		return NewMocknetNode(s.Dir, sharedMocknet()), nil
+	},
+	"remote": func(s NodeSpec) (TestbedNode, error) {
+		if s.Addr == "" {
+			return nil, fmt.Errorf("remote node %s: missing \"addr\"", s.Dir)
+		}
+		return &RemoteNode{Addr: s.Addr}, nil
+	},
+	"filecoin": func(s NodeSpec) (TestbedNode, error) {
+		return &FilecoinNode{Dir: s.Dir}, nil
+	},
+}
+
+var (
+	mocknetOnce   sync.Once
+	mocknetShared mocknet.Mocknet
+)
+
+// sharedMocknet lazily builds the one mocknet.Mocknet every mocknet-typed
+// node of a testbed joins, so that LinkAllMocknet can link them all
+// together once LoadNodes' caller has started them.
+func sharedMocknet() mocknet.Mocknet {
+	mocknetOnce.Do(func() {
+		mocknetShared = mocknet.New(context.Background())
+	})
+	return mocknetShared
+}
+
+// TestbedMocknet returns the mocknet.Mocknet shared by this testbed's
+// mocknet-typed nodes, building it if LoadNodes hasn't already. Callers
+// pass it to LinkAllMocknet once every node is started.
+func TestbedMocknet() mocknet.Mocknet {
+	return sharedMocknet()
+}
+
+// iptbRoot returns the directory a testbed's node specs and per-node
+// dirs live under, honoring $IPTB_ROOT with a fallback to ~/testbed.
+func iptbRoot() (string, error) {
+	if root := os.Getenv("IPTB_ROOT"); root != "" {
+		return root, nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, "testbed"), nil
+}
+
+// LoadNodes reads $IPTB_ROOT/nodespec.json and constructs the
+// TestbedNode each entry describes, dispatching on its "type" field
+// through nodeFactories. It's how `iptb shell` and friends recover the
+// rest of a testbed's nodes from disk to populate NODE<i> env vars.
+func LoadNodes() ([]TestbedNode, error) {
+	root, err := iptbRoot()
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(root, "nodespec.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var specs []NodeSpec
+	if err := json.Unmarshal(b, &specs); err != nil {
+		return nil, err
+	}
+
+	nodes := make([]TestbedNode, len(specs))
+	for i, s := range specs {
+		factory, ok := nodeFactories[s.Type]
+		if !ok {
+			return nil, fmt.Errorf("node %d: unrecognized type %q", i, s.Type)
+		}
+
+		n, err := factory(s)
+		if err != nil {
+			return nil, err
+		}
+		nodes[i] = n
+	}
+
+	return nodes, nil
+}
@@ -0,0 +1,267 @@
+package iptbutil
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/casebell/iptb/agent/pb"
+	"github.com/ipfs/go-ipfs/repo/config"
+
+	"google.golang.org/grpc"
+)
+
+var _ TestbedNode = (*RemoteNode)(nil)
+
+// RemoteNode is a TestbedNode that drives a daemon on another host via
+// the iptb-agent gRPC protocol, rather than exec'ing or constructing it
+// locally. LoadNodes recognizes a "type: remote" testbed entry with an
+// "addr" field and produces one of these, dialing the agent lazily.
+type RemoteNode struct {
+	Addr   string // host:port the iptb-agent for this node listens on
+	PeerID string
+
+	conn   *grpc.ClientConn
+	client pb.AgentClient
+}
+
+func (n *RemoteNode) dial() (pb.AgentClient, error) {
+	if n.client != nil {
+		return n.client, nil
+	}
+
+	conn, err := grpc.Dial(n.Addr, grpc.WithInsecure())
+	if err != nil {
+		return nil, fmt.Errorf("dialing agent at %s: %s", n.Addr, err)
+	}
+
+	n.conn = conn
+	n.client = pb.NewAgentClient(conn)
+	return n.client, nil
+}
+
+func (n *RemoteNode) Init() error {
+	c, err := n.dial()
+	if err != nil {
+		return err
+	}
+
+	_, err = c.Init(context.Background(), &pb.InitRequest{})
+	return err
+}
+
+func (n *RemoteNode) Start(args []string) error {
+	c, err := n.dial()
+	if err != nil {
+		return err
+	}
+
+	reply, err := c.Start(context.Background(), &pb.StartRequest{Args: args})
+	if err != nil {
+		return err
+	}
+
+	n.PeerID = reply.PeerId
+	return nil
+}
+
+func (n *RemoteNode) Kill() error {
+	c, err := n.dial()
+	if err != nil {
+		return err
+	}
+
+	_, err = c.Kill(context.Background(), &pb.KillRequest{})
+	return err
+}
+
+func (n *RemoteNode) RunCmd(args ...string) (string, error) {
+	c, err := n.dial()
+	if err != nil {
+		return "", err
+	}
+
+	reply, err := c.RunCmd(context.Background(), &pb.RunCmdRequest{Args: args})
+	if err != nil {
+		return "", err
+	}
+
+	return reply.Output, nil
+}
+
+// Logs streams this node's remote daemon output to w until ctx is
+// canceled or the agent closes the stream. It backs `iptb logs -f` for
+// remote testbeds.
+func (n *RemoteNode) Logs(ctx context.Context, w io.Writer) error {
+	c, err := n.dial()
+	if err != nil {
+		return err
+	}
+
+	stream, err := c.Events(ctx, &pb.EventsRequest{})
+	if err != nil {
+		return err
+	}
+
+	for {
+		ev, err := stream.Recv()
+		if err == io.EOF || ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if ev.Output != nil {
+			if _, err := w.Write(ev.Output.Data); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (n *RemoteNode) APIAddr() (string, error) {
+	c, err := n.dial()
+	if err != nil {
+		return "", err
+	}
+
+	reply, err := c.APIAddr(context.Background(), &pb.APIAddrRequest{})
+	if err != nil {
+		return "", err
+	}
+	return reply.Addr, nil
+}
+
+func (n *RemoteNode) GetPeerID() string {
+	return n.PeerID
+}
+
+func (n *RemoteNode) String() string {
+	return n.PeerID
+}
+
+// Shell is not supported for remote nodes: there is no local shell to
+// export NODE<i> env vars into that also has network access to the
+// remote agent's API. It always returns an error.
+func (n *RemoteNode) Shell() error {
+	return errors.New("shell is not supported for remote nodes")
+}
+
+func (n *RemoteNode) BinName() string {
+	return "ipfs"
+}
+
+func (n *RemoteNode) GetAttr(attr string) (string, error) {
+	switch attr {
+	case attrId:
+		return n.GetPeerID(), nil
+	default:
+		return "", errors.New("unrecognized attribute: " + attr)
+	}
+}
+
+func (n *RemoteNode) SetAttr(name, val string) error {
+	return fmt.Errorf("no attributes to set")
+}
+
+// Events relays the remote agent's Event stream, translating the wire
+// STARTED/DIED/SAMPLE/RESTARTED kinds back to NodeEvent. OUTPUT events
+// (the raw log tailing RemoteNode.Logs consumes) are not lifecycle
+// events and are skipped here.
+func (n *RemoteNode) Events(ctx context.Context) (<-chan NodeEvent, error) {
+	c, err := n.dial()
+	if err != nil {
+		return nil, err
+	}
+
+	stream, err := c.Events(ctx, &pb.EventsRequest{})
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan NodeEvent)
+	go func() {
+		defer close(out)
+		for {
+			ev, err := stream.Recv()
+			if err != nil {
+				return
+			}
+
+			kind, ok := fromWireEventKind(ev.Kind)
+			if !ok {
+				continue
+			}
+
+			ne := NodeEvent{PeerID: n.PeerID, Kind: kind, Time: time.Unix(0, ev.UnixNano)}
+			if ev.Sample != nil {
+				ne.Sample = NodeSample{
+					BWIn:          ev.Sample.BwIn,
+					BWOut:         ev.Sample.BwOut,
+					CPUUsage:      ev.Sample.CpuUsage,
+					MemoryCurrent: ev.Sample.MemoryCurrent,
+					RepoSize:      ev.Sample.RepoSize,
+					PeerCount:     int(ev.Sample.PeerCount),
+				}
+			}
+			select {
+			case out <- ne:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+func fromWireEventKind(k pb.Event_Kind) (EventKind, bool) {
+	switch k {
+	case pb.Event_STARTED:
+		return EventStarted, true
+	case pb.Event_DIED:
+		return EventDied, true
+	case pb.Event_SAMPLE:
+		return EventSample, true
+	case pb.Event_RESTARTED:
+		return EventRestarted, true
+	default:
+		return 0, false
+	}
+}
+
+func (n *RemoteNode) GetConfig() (*config.Config, error) {
+	c, err := n.dial()
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := c.GetConfig(context.Background(), &pb.GetConfigRequest{})
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := new(config.Config)
+	if err := json.Unmarshal(reply.ConfigJson, cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (n *RemoteNode) WriteConfig(cfg *config.Config) error {
+	c, err := n.dial()
+	if err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.WriteConfig(context.Background(), &pb.WriteConfigRequest{ConfigJson: b})
+	return err
+}
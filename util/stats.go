@@ -0,0 +1,173 @@
+package iptbutil
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// eventBufSize bounds how many past events StatsReporter keeps around
+// for late subscribers (e.g. a freshly attached `iptb events -f`).
+const eventBufSize = 1024
+
+// StatsReporter fans the Events() stream of every node in a testbed
+// into one place: a ring buffer for replay, a live subscriber channel,
+// and, optionally, an NDJSON file under ~/.iptb/events.log. It mirrors
+// the common task-state-plus-LatestAllocStats pattern: callers can
+// either subscribe to the live stream or just ask for the latest sample
+// of a given node.
+type StatsReporter struct {
+	nodes []TestbedNode
+
+	buf *ringBuffer
+
+	mu      sync.RWMutex
+	latest  map[string]NodeEvent
+	subs    map[chan NodeEvent]struct{}
+	logFile *os.File
+}
+
+// NewStatsReporter builds a reporter for nodes. If logPath is non-empty,
+// every event is additionally appended to it as one NDJSON line; the
+// conventional path is ~/.iptb/events.log.
+func NewStatsReporter(nodes []TestbedNode, logPath string) (*StatsReporter, error) {
+	r := &StatsReporter{
+		nodes:  nodes,
+		buf:    newRingBuffer(eventBufSize),
+		latest: make(map[string]NodeEvent),
+		subs:   make(map[chan NodeEvent]struct{}),
+	}
+
+	if logPath != "" {
+		if err := os.MkdirAll(filepath.Dir(logPath), 0755); err != nil {
+			return nil, err
+		}
+
+		f, err := os.OpenFile(logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, err
+		}
+		r.logFile = f
+	}
+
+	return r, nil
+}
+
+// Run subscribes to every node's Events() and feeds them into the
+// reporter until ctx is canceled. It blocks; call it in its own
+// goroutine.
+func (r *StatsReporter) Run(ctx context.Context) error {
+	var wg sync.WaitGroup
+
+	for _, node := range r.nodes {
+		ch, err := node.Events(ctx)
+		if err != nil {
+			return fmt.Errorf("subscribing to %s: %s", node, err)
+		}
+
+		wg.Add(1)
+		go func(ch <-chan NodeEvent) {
+			defer wg.Done()
+			for ev := range ch {
+				r.record(ev)
+			}
+		}(ch)
+	}
+
+	<-ctx.Done()
+	wg.Wait()
+	if r.logFile != nil {
+		return r.logFile.Close()
+	}
+	return nil
+}
+
+func (r *StatsReporter) record(ev NodeEvent) {
+	r.buf.push(ev)
+
+	r.mu.Lock()
+	if ev.Kind == EventSample {
+		r.latest[ev.PeerID] = ev
+	}
+	for sub := range r.subs {
+		select {
+		case sub <- ev:
+		default:
+			// slow subscriber; drop rather than block the reporter
+		}
+	}
+	r.mu.Unlock()
+
+	if r.logFile != nil {
+		if b, err := json.Marshal(ev); err == nil {
+			r.logFile.Write(append(b, '\n'))
+		}
+	}
+}
+
+// Subscribe returns a channel of live events and a function to stop
+// receiving them. Used by `iptb events -f`.
+func (r *StatsReporter) Subscribe() (<-chan NodeEvent, func()) {
+	ch := make(chan NodeEvent, 64)
+
+	r.mu.Lock()
+	r.subs[ch] = struct{}{}
+	r.mu.Unlock()
+
+	cancel := func() {
+		r.mu.Lock()
+		delete(r.subs, ch)
+		r.mu.Unlock()
+		close(ch)
+	}
+	return ch, cancel
+}
+
+// Replay returns the buffered event history, oldest first.
+func (r *StatsReporter) Replay() []NodeEvent {
+	return r.buf.snapshot()
+}
+
+// LatestSample returns the most recent EventSample recorded for peerID.
+func (r *StatsReporter) LatestSample(peerID string) (NodeEvent, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	ev, ok := r.latest[peerID]
+	return ev, ok
+}
+
+// WritePrometheus renders the latest sample of every node in the
+// Prometheus text exposition format, for `iptb stats --format prom`.
+func (r *StatsReporter) WritePrometheus(w io.Writer) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	fmt.Fprintln(w, "# HELP iptb_node_bandwidth_bytes Cumulative bandwidth seen by a node.")
+	fmt.Fprintln(w, "# TYPE iptb_node_bandwidth_bytes counter")
+	fmt.Fprintln(w, "# HELP iptb_node_memory_current_bytes Current cgroup memory usage.")
+	fmt.Fprintln(w, "# TYPE iptb_node_memory_current_bytes gauge")
+	fmt.Fprintln(w, "# HELP iptb_node_cpu_usage_seconds_total Cumulative cgroup CPU usage.")
+	fmt.Fprintln(w, "# TYPE iptb_node_cpu_usage_seconds_total counter")
+	fmt.Fprintln(w, "# HELP iptb_node_repo_size_bytes Size of a node's repo directory on disk.")
+	fmt.Fprintln(w, "# TYPE iptb_node_repo_size_bytes gauge")
+	fmt.Fprintln(w, "# HELP iptb_node_peer_count Number of peers a node is currently connected to.")
+	fmt.Fprintln(w, "# TYPE iptb_node_peer_count gauge")
+	for peerID, ev := range r.latest {
+		if ev.Kind != EventSample {
+			continue
+		}
+		fmt.Fprintf(w, "iptb_node_bandwidth_bytes{peer=%q,direction=\"in\"} %d\n", peerID, ev.Sample.BWIn)
+		fmt.Fprintf(w, "iptb_node_bandwidth_bytes{peer=%q,direction=\"out\"} %d\n", peerID, ev.Sample.BWOut)
+		fmt.Fprintf(w, "iptb_node_memory_current_bytes{peer=%q} %d\n", peerID, ev.Sample.MemoryCurrent)
+		// CPUUsage is in microseconds
+		fmt.Fprintf(w, "iptb_node_cpu_usage_seconds_total{peer=%q} %g\n", peerID, float64(ev.Sample.CPUUsage)/1e6)
+		fmt.Fprintf(w, "iptb_node_repo_size_bytes{peer=%q} %d\n", peerID, ev.Sample.RepoSize)
+		fmt.Fprintf(w, "iptb_node_peer_count{peer=%q} %d\n", peerID, ev.Sample.PeerCount)
+	}
+	return nil
+}